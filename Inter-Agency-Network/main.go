@@ -8,14 +8,127 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
         "os"
+	"strconv"
+	"strings"
 
+	gocid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+
+	"github.com/Blockchaincarechildren/institutionalized-children/Inter-Agency-Network/pkg/events"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 )
 
+// adminConfigKey is the world-state key under which the admin MSP/OU configured at Init time
+// is stored
+const adminConfigKey = "~adminConfig"
+
+// eventConfigKey is the world-state key under which the event name prefix configured at Init
+// time is stored
+const eventConfigKey = "~eventConfig"
+
+// institutionWhitelistKey is the world-state key under which the list of institutions allowed
+// to hold files (orphanage, hospital, social-services agency, ...) is stored
+const institutionWhitelistKey = "~institutionWhitelist"
+
+// fileHistoryIndexName is the composite-key index getFileHistory/getFileProvenance read back.
+// Private data collections have no equivalent of GetHistoryForKey (that API only walks keys
+// written to the public world state via PutState, and this chaincode never calls PutState for
+// file records), so every mutating call appends an entry here instead.
+const fileHistoryIndexName = "history~name"
+
+// filesCollectionFor returns the private data collection that holds file records for institution
+func filesCollectionFor(institution string) string {
+	return "collection_" + institution + "_Files"
+}
+
+// detailsCollectionFor returns the private data collection that holds file private details for institution
+func detailsCollectionFor(institution string) string {
+	return "collection_" + institution + "_Details"
+}
+
+// transferCollectionFor returns the private data collection both source and dest institutions
+// write a hash-verifiable record to when a file crosses institutions
+func transferCollectionFor(source, dest string) string {
+	return fmt.Sprintf("collection%s_to_%sTransfers", source, dest)
+}
+
+// getInstitutionWhitelist returns the institutions whitelisted at Init time, or nil if none was
+// configured
+func getInstitutionWhitelist(stub shim.ChaincodeStubInterface) ([]string, error) {
+	whitelistBytes, err := stub.GetState(institutionWhitelistKey)
+	if err != nil {
+		return nil, err
+	}
+	if whitelistBytes == nil {
+		return nil, nil
+	}
+
+	var whitelist []string
+	if err := json.Unmarshal(whitelistBytes, &whitelist); err != nil {
+		return nil, err
+	}
+	return whitelist, nil
+}
+
+// isInstitutionWhitelisted reports whether institution is in the Init-time whitelist. If no
+// whitelist was configured, institution validation is left disabled.
+func isInstitutionWhitelisted(stub shim.ChaincodeStubInterface, institution string) (bool, error) {
+	whitelist, err := getInstitutionWhitelist(stub)
+	if err != nil {
+		return false, err
+	}
+	if whitelist == nil {
+		return true, nil
+	}
+	for _, candidate := range whitelist {
+		if candidate == institution {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// candidateFilesCollections resolves which collections to search for a file read. When
+// institution is known, only its collection is searched; otherwise every whitelisted
+// institution's collection is tried, so a caller without prior knowledge of the owning
+// institution still only ever sees entries their own MSP can decrypt.
+func candidateFilesCollections(stub shim.ChaincodeStubInterface, institution string) ([]string, error) {
+	if institution != "" {
+		return []string{filesCollectionFor(institution)}, nil
+	}
+	whitelist, err := getInstitutionWhitelist(stub)
+	if err != nil {
+		return nil, err
+	}
+	collections := make([]string, 0, len(whitelist))
+	for _, candidate := range whitelist {
+		collections = append(collections, filesCollectionFor(candidate))
+	}
+	return collections, nil
+}
+
+// candidateDetailsCollections is the filePrivateDetails counterpart of candidateFilesCollections
+func candidateDetailsCollections(stub shim.ChaincodeStubInterface, institution string) ([]string, error) {
+	if institution != "" {
+		return []string{detailsCollectionFor(institution)}, nil
+	}
+	whitelist, err := getInstitutionWhitelist(stub)
+	if err != nil {
+		return nil, err
+	}
+	collections := make([]string, 0, len(whitelist))
+	for _, candidate := range whitelist {
+		collections = append(collections, detailsCollectionFor(candidate))
+	}
+	return collections, nil
+}
+
 // FilesPrivateChaincode example Chaincode implementation
 type FilesPrivateChaincode struct {
 }
@@ -24,8 +137,10 @@ type file struct {
 	ObjectType string `json:"docType"` //docType is used to distinguish the various types of objects in state database
 	Name       string `json:"name"`    //the fieldtags are needed to keep case from bouncing around
 	IPFShash      string `json:"ipfshash"`
+	ContentSHA256 string `json:"contentSha256"` //sha2-256 digest of the file content, hex-encoded; must match the ipfshash's multihash digest
 	Timestamp       int    `json:"timestamp"`
 	Owner      string `json:"owner"`
+	Institution string `json:"institution"` //owning institution; determines which collection_<institution>_Files the file lives in
 }
 
 type filePrivateDetails struct {
@@ -34,9 +149,206 @@ type filePrivateDetails struct {
 	Folio      int    `json:"folio"`
 }
 
+// adminConfig records the admin MSP ID / OU configured at Init time
+type adminConfig struct {
+	AdminMSPID string `json:"adminMspId"`
+	AdminOU    string `json:"adminOu"`
+}
+
+// eventConfig records the event name prefix configured at Init time, letting orgs that share
+// a chaincode deployment distinguish whose lifecycle events they're subscribing to
+type eventConfig struct {
+	EventPrefix string `json:"eventPrefix"`
+}
+
+// eventName builds the event name for baseName, qualifying it with the configured event
+// prefix (if any) so subscribers on a shared deployment can filter events by org
+func eventName(stub shim.ChaincodeStubInterface, baseName string) (string, error) {
+	cfgBytes, err := stub.GetState(eventConfigKey)
+	if err != nil {
+		return "", err
+	}
+	if cfgBytes == nil {
+		return baseName, nil
+	}
+
+	var cfg eventConfig
+	if err := json.Unmarshal(cfgBytes, &cfg); err != nil {
+		return "", err
+	}
+	if cfg.EventPrefix == "" {
+		return baseName, nil
+	}
+	return cfg.EventPrefix + "." + baseName, nil
+}
+
+// validateAndCanonicalizeCID parses ipfshash as a CIDv0 or CIDv1 string, enforces that its
+// multihash is sha2-256, and - if contentSHA256Hex is non-empty - verifies that the CID's
+// multihash digest matches the supplied hex-encoded sha256 digest. It returns the CID's
+// canonical string form.
+func validateAndCanonicalizeCID(ipfshash string, contentSHA256Hex string) (string, error) {
+	parsed, err := gocid.Decode(ipfshash)
+	if err != nil {
+		return "", fmt.Errorf("ipfshash is not a valid CIDv0/CIDv1 string: %v", err)
+	}
+
+	decodedMH, err := mh.Decode(parsed.Hash())
+	if err != nil {
+		return "", fmt.Errorf("ipfshash does not carry a decodable multihash: %v", err)
+	}
+	if decodedMH.Code != mh.SHA2_256 {
+		return "", fmt.Errorf("ipfshash multihash must be sha2-256")
+	}
+
+	if contentSHA256Hex != "" {
+		expected := hex.EncodeToString(decodedMH.Digest)
+		if !strings.EqualFold(expected, contentSHA256Hex) {
+			return "", fmt.Errorf("contentSha256 does not match the ipfshash multihash digest")
+		}
+	}
+
+	return parsed.String(), nil
+}
+
+// setFileLifecycleEvent emits a lifecycle event under the given base name, wrapped in the
+// pkg/events versioned envelope (sig + version + payload) so SDK clients can decode a stable
+// schema via events.UnpackLog instead of parsing ad-hoc JSON.
+func setFileLifecycleEvent(stub shim.ChaincodeStubInterface, baseName string, sig string, payload interface{}) error {
+	name, err := eventName(stub, baseName)
+	if err != nil {
+		return err
+	}
+	packed, err := events.Pack(sig, 1, payload)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(name, packed)
+}
+
+// accessDeniedError is the structured payload returned when an ACL check fails
+type accessDeniedError struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func accessDenied(code, reason string) pb.Response {
+	errBytes, _ := json.Marshal(accessDeniedError{Code: code, Reason: reason})
+	return shim.Error(string(errBytes))
+}
+
+// requireRole returns an error unless the invoking identity carries the given custom attribute
+// role=<role>, as resolved via the fabric-chaincode-go CID library
+func requireRole(stub shim.ChaincodeStubInterface, role string) error {
+	value, found, err := cid.GetAttributeValue(stub, "role")
+	if err != nil {
+		return err
+	}
+	if !found || value != role {
+		return fmt.Errorf("caller does not have required role: %s", role)
+	}
+	return nil
+}
+
+// requireAdmin requires role=admin, and additionally - when an admin MSP/OU was configured at
+// Init time via the admin_config transient input - requires the caller's MSPID and/or OU to
+// match it. With no admin_config stored, this is equivalent to requireRole(stub, "admin").
+func requireAdmin(stub shim.ChaincodeStubInterface) error {
+	if err := requireRole(stub, "admin"); err != nil {
+		return err
+	}
+
+	cfgBytes, err := stub.GetState(adminConfigKey)
+	if err != nil {
+		return err
+	}
+	if cfgBytes == nil {
+		return nil
+	}
+
+	var cfg adminConfig
+	if err := json.Unmarshal(cfgBytes, &cfg); err != nil {
+		return err
+	}
+
+	if cfg.AdminMSPID != "" {
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return err
+		}
+		if mspID != cfg.AdminMSPID {
+			return fmt.Errorf("caller's MSP %s is not the configured admin MSP", mspID)
+		}
+	}
+
+	if cfg.AdminOU != "" {
+		hasOU, err := cid.HasOUValue(stub, cfg.AdminOU)
+		if err != nil {
+			return err
+		}
+		if !hasOU {
+			return fmt.Errorf("caller does not carry the configured admin OU: %s", cfg.AdminOU)
+		}
+	}
+
+	return nil
+}
+
 // Init initializes chaincode
 // ===========================
+// If an admin_config key is present in the transient map, it is stored and enforced by
+// requireAdmin on top of the role=admin attribute check; missing it simply leaves
+// attribute-based checks (role=...) as the only ACL in effect.
 func (t *FilesPrivateChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	transMap, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("Error getting transient: " + err.Error())
+	}
+
+	if adminConfigBytes, ok := transMap["admin_config"]; ok && len(adminConfigBytes) > 0 {
+		var cfg adminConfig
+		if err := json.Unmarshal(adminConfigBytes, &cfg); err != nil {
+			return shim.Error("Failed to decode JSON of: " + string(adminConfigBytes))
+		}
+
+		cfgBytes, err := json.Marshal(cfg)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.PutState(adminConfigKey, cfgBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	if eventConfigBytes, ok := transMap["event_config"]; ok && len(eventConfigBytes) > 0 {
+		var cfg eventConfig
+		if err := json.Unmarshal(eventConfigBytes, &cfg); err != nil {
+			return shim.Error("Failed to decode JSON of: " + string(eventConfigBytes))
+		}
+
+		cfgBytes, err := json.Marshal(cfg)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.PutState(eventConfigKey, cfgBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	if whitelistBytes, ok := transMap["institution_whitelist"]; ok && len(whitelistBytes) > 0 {
+		var whitelist []string
+		if err := json.Unmarshal(whitelistBytes, &whitelist); err != nil {
+			return shim.Error("Failed to decode JSON of: " + string(whitelistBytes))
+		}
+
+		cfgBytes, err := json.Marshal(whitelist)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.PutState(institutionWhitelistKey, cfgBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	return shim.Success(nil)
 }
 
@@ -72,6 +384,30 @@ func (t *FilesPrivateChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Resp
 	case "getFilePrivateDetailsHash":
 		// get private data hash for collectionFilePrivateDetails
 		return t.getFilePrivateDetailsHash(stub, args)
+	case "getFileHistory":
+		// get the change history for a file
+		return t.getFileHistory(stub, args)
+	case "getFileProvenance":
+		// get the condensed chain of custody for a file
+		return t.getFileProvenance(stub, args)
+	case "queryFilesByOwner":
+		// query for files by owner, using a CouchDB Mango selector
+		return t.queryFilesByOwner(stub, args)
+	case "queryFiles":
+		// query for files using an arbitrary CouchDB Mango selector
+		return t.queryFiles(stub, args)
+	case "queryFilesWithPagination":
+		// query for files using an arbitrary CouchDB Mango selector, with pagination
+		return t.queryFilesWithPagination(stub, args)
+	case "getCallerIdentity":
+		// resolve the invoking identity's MSPID/OU/attrs, for ACL debugging
+		return t.getCallerIdentity(stub, args)
+	case "updateFileHash":
+		// update a file's IPFS CID, re-verifying it against the supplied content hash
+		return t.updateFileHash(stub, args)
+	case "verifyFileHash":
+		// check a sha256 digest against the CID stored for a file
+		return t.verifyFileHash(stub, args)
 	default:
 		//error
 		fmt.Println("invoke did not find func: " + function)
@@ -88,14 +424,20 @@ func (t *FilesPrivateChaincode) initFile(stub shim.ChaincodeStubInterface, args
 	type fileTransientInput struct {
 		Name  string `json:"name"` //the fieldtags are needed to keep case from bouncing around
 		IPFShash string `json:"ipfshash"`
+		ContentSHA256 string `json:"contentSha256"`
 		Timestamp  int    `json:"size"`
 		Owner string `json:"owner"`
 		Folio int    `json:"folio"`
+		Institution string `json:"institution"`
 	}
 
 	// ==== Input sanitation ====
 	fmt.Println("- start init file")
 
+	if err := requireRole(stub, "caseworker"); err != nil {
+		return accessDenied("ERR_FORBIDDEN", err.Error())
+	}
+
 	if len(args) != 0 {
 		return shim.Error("Incorrect number of arguments. Private file data must be passed in transient map.")
 	}
@@ -126,6 +468,9 @@ func (t *FilesPrivateChaincode) initFile(stub shim.ChaincodeStubInterface, args
 	if len(fileInput.IPFShash) == 0 {
 		return shim.Error("ipfshash field must be a non-empty string")
 	}
+	if len(fileInput.ContentSHA256) == 0 {
+		return shim.Error("contentSha256 field must be a non-empty string")
+	}
 	if fileInput.Timestamp <= 0 {
 		return shim.Error("size field must be a positive integer")
 	}
@@ -135,9 +480,26 @@ func (t *FilesPrivateChaincode) initFile(stub shim.ChaincodeStubInterface, args
 	if fileInput.Folio <= 0 {
 		return shim.Error("folio field must be a positive integer")
 	}
+	if len(fileInput.Institution) == 0 {
+		return shim.Error("institution field must be a non-empty string")
+	}
+	if whitelisted, err := isInstitutionWhitelisted(stub, fileInput.Institution); err != nil {
+		return shim.Error(err.Error())
+	} else if !whitelisted {
+		return shim.Error("institution is not whitelisted: " + fileInput.Institution)
+	}
+
+	canonicalCID, err := validateAndCanonicalizeCID(fileInput.IPFShash, fileInput.ContentSHA256)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fileInput.IPFShash = canonicalCID
+
+	filesCollection := filesCollectionFor(fileInput.Institution)
+	detailsCollection := detailsCollectionFor(fileInput.Institution)
 
 	// ==== Check if file already exists ====
-	fileAsBytes, err := stub.GetPrivateData("collectionFiles", fileInput.Name)
+	fileAsBytes, err := stub.GetPrivateData(filesCollection, fileInput.Name)
 	if err != nil {
 		return shim.Error("Failed to get file: " + err.Error())
 	} else if fileAsBytes != nil {
@@ -150,8 +512,10 @@ func (t *FilesPrivateChaincode) initFile(stub shim.ChaincodeStubInterface, args
 		ObjectType: "file",
 		Name:       fileInput.Name,
 		IPFShash:      fileInput.IPFShash,
+		ContentSHA256: fileInput.ContentSHA256,
 		Timestamp:       fileInput.Timestamp,
 		Owner:      fileInput.Owner,
+		Institution: fileInput.Institution,
 	}
 	fileJSONasBytes, err := json.Marshal(file)
 	if err != nil {
@@ -159,7 +523,7 @@ func (t *FilesPrivateChaincode) initFile(stub shim.ChaincodeStubInterface, args
 	}
 
 	// === Save file to state ===
-	err = stub.PutPrivateData("collectionFiles", fileInput.Name, fileJSONasBytes)
+	err = stub.PutPrivateData(filesCollection, fileInput.Name, fileJSONasBytes)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -174,7 +538,7 @@ func (t *FilesPrivateChaincode) initFile(stub shim.ChaincodeStubInterface, args
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = stub.PutPrivateData("collectionFilePrivateDetails", fileInput.Name, filePrivateDetailsBytes)
+	err = stub.PutPrivateData(detailsCollection, fileInput.Name, filePrivateDetailsBytes)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -192,7 +556,27 @@ func (t *FilesPrivateChaincode) initFile(stub shim.ChaincodeStubInterface, args
 	//  Save index entry to state. Only the key name is needed, no need to store a duplicate copy of the file.
 	//  Note - passing a 'nil' value will effectively delete the key from state, therefore we pass null character as value
 	value := []byte{0x00}
-	stub.PutPrivateData("collectionFiles", ipfshashNameIndexKey, value)
+	stub.PutPrivateData(filesCollection, ipfshashNameIndexKey, value)
+
+	// ==== Append this write to the history~name index getFileHistory/getFileProvenance read ====
+	if err := recordFileHistoryEntry(stub, filesCollection, file.Name, false, fileJSONasBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// ==== Notify off-chain listeners (notification daemons, IPFS GC, audit dashboards) ====
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := setFileLifecycleEvent(stub, "FileCreated", events.SigFileCreated, events.FileCreatedEvent{
+		Name:      file.Name,
+		IPFShash:  file.IPFShash,
+		Owner:     file.Owner,
+		TxID:      stub.GetTxID(),
+		Timestamp: txTimestamp.Seconds,
+	}); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	// ==== File saved and indexed. Return success ====
 	fmt.Println("- end init file")
@@ -200,99 +584,120 @@ func (t *FilesPrivateChaincode) initFile(stub shim.ChaincodeStubInterface, args
 }
 
 // ===============================================
-// readFile - read a file from chaincode state
+// readFile - read a file from chaincode state. args is [name] or [name, institution]; when
+// institution is omitted, every whitelisted institution's collection is tried and only the
+// entry the caller's MSP can actually decrypt is returned.
 // ===============================================
 func (t *FilesPrivateChaincode) readFile(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the file to query and optional institution")
+	}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the file to query")
+	name := args[0]
+	institution := ""
+	if len(args) == 2 {
+		institution = args[1]
 	}
 
-	name = args[0]
-	valAsbytes, err := stub.GetPrivateData("collectionFiles", name) //get the file from chaincode state
+	collections, err := candidateFilesCollections(stub, institution)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + ": " + err.Error() + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"File does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return shim.Error(err.Error())
+	}
+	for _, collection := range collections {
+		valAsbytes, err := stub.GetPrivateData(collection, name) //get the file from chaincode state
+		if err == nil && valAsbytes != nil {
+			return shim.Success(valAsbytes)
+		}
 	}
 
-	return shim.Success(valAsbytes)
+	return shim.Error("{\"Error\":\"File does not exist: " + name + "\"}")
 }
 
 // ===============================================
-// readFilereadFilePrivateDetails - read a file private details from chaincode state
+// readFilePrivateDetails - read a file private details from chaincode state. Same args shape
+// as readFile.
 // ===============================================
 func (t *FilesPrivateChaincode) readFilePrivateDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the file to query and optional institution")
+	}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the file to query")
+	name := args[0]
+	institution := ""
+	if len(args) == 2 {
+		institution = args[1]
 	}
 
-	name = args[0]
-	valAsbytes, err := stub.GetPrivateData("collectionFilePrivateDetails", name) //get the file private details from chaincode state
+	collections, err := candidateDetailsCollections(stub, institution)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get private details for " + name + ": " + err.Error() + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"File private details does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return shim.Error(err.Error())
+	}
+	for _, collection := range collections {
+		valAsbytes, err := stub.GetPrivateData(collection, name) //get the file private details from chaincode state
+		if err == nil && valAsbytes != nil {
+			return shim.Success(valAsbytes)
+		}
 	}
 
-	return shim.Success(valAsbytes)
+	return shim.Error("{\"Error\":\"File private details does not exist: " + name + "\"}")
 }
 
 // ===============================================
-// getFileHash - get file private data hash for collectionFiles from chaincode state
+// getFileHash - get file private data hash for a file's Files collection from chaincode state.
+// Same args shape as readFile.
 // ===============================================
 func (t *FilesPrivateChaincode) getFileHash(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the file to query and optional institution")
+	}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the file to query")
+	name := args[0]
+	institution := ""
+	if len(args) == 2 {
+		institution = args[1]
 	}
 
-	name = args[0]
-	valAsbytes, err := stub.GetPrivateDataHash("collectionFiles", name)
+	collections, err := candidateFilesCollections(stub, institution)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get file private data hash for " + name + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"File private file data hash does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return shim.Error(err.Error())
+	}
+	for _, collection := range collections {
+		valAsbytes, err := stub.GetPrivateDataHash(collection, name)
+		if err == nil && valAsbytes != nil {
+			return shim.Success(valAsbytes)
+		}
 	}
 
-	return shim.Success(valAsbytes)
+	return shim.Error("{\"Error\":\"File private file data hash does not exist: " + name + "\"}")
 }
 
 // ===============================================
-// getFilePrivateDetailsHash - get file private data hash for collectionFilePrivateDetails from chaincode state
+// getFilePrivateDetailsHash - get file private data hash for a file's Details collection from
+// chaincode state. Same args shape as readFile.
 // ===============================================
 func (t *FilesPrivateChaincode) getFilePrivateDetailsHash(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting name of the file to query and optional institution")
+	}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the file to query")
+	name := args[0]
+	institution := ""
+	if len(args) == 2 {
+		institution = args[1]
 	}
 
-	name = args[0]
-	valAsbytes, err := stub.GetPrivateDataHash("collectionFilePrivateDetails", name)
+	collections, err := candidateDetailsCollections(stub, institution)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get file private details hash for " + name + ": " + err.Error() + "\"}"
-		return shim.Error(jsonResp)
-	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"File private details hash does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return shim.Error(err.Error())
+	}
+	for _, collection := range collections {
+		valAsbytes, err := stub.GetPrivateDataHash(collection, name)
+		if err == nil && valAsbytes != nil {
+			return shim.Success(valAsbytes)
+		}
 	}
 
-	return shim.Success(valAsbytes)
+	return shim.Error("{\"Error\":\"File private details hash does not exist: " + name + "\"}")
 }
 
 // ==================================================
@@ -301,8 +706,13 @@ func (t *FilesPrivateChaincode) getFilePrivateDetailsHash(stub shim.ChaincodeStu
 func (t *FilesPrivateChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	fmt.Println("- start delete file")
 
+	if err := requireAdmin(stub); err != nil {
+		return accessDenied("ERR_FORBIDDEN", err.Error())
+	}
+
 	type fileDeleteTransientInput struct {
-		Name string `json:"name"`
+		Name        string `json:"name"`
+		Institution string `json:"institution"`
 	}
 
 	if len(args) != 0 {
@@ -332,9 +742,15 @@ func (t *FilesPrivateChaincode) delete(stub shim.ChaincodeStubInterface, args []
 	if len(fileDeleteInput.Name) == 0 {
 		return shim.Error("name field must be a non-empty string")
 	}
+	if len(fileDeleteInput.Institution) == 0 {
+		return shim.Error("institution field must be a non-empty string")
+	}
+
+	filesCollection := filesCollectionFor(fileDeleteInput.Institution)
+	detailsCollection := detailsCollectionFor(fileDeleteInput.Institution)
 
 	// to maintain the ipfshash~name index, we need to read the file first and get its ipfshash
-	valAsbytes, err := stub.GetPrivateData("collectionFiles", fileDeleteInput.Name) //get the file from chaincode state
+	valAsbytes, err := stub.GetPrivateData(filesCollection, fileDeleteInput.Name) //get the file from chaincode state
 	if err != nil {
 		return shim.Error("Failed to get state for " + fileDeleteInput.Name)
 	} else if valAsbytes == nil {
@@ -348,7 +764,7 @@ func (t *FilesPrivateChaincode) delete(stub shim.ChaincodeStubInterface, args []
 	}
 
 	// delete the file from state
-	err = stub.DelPrivateData("collectionFiles", fileDeleteInput.Name)
+	err = stub.DelPrivateData(filesCollection, fileDeleteInput.Name)
 	if err != nil {
 		return shim.Error("Failed to delete state:" + err.Error())
 	}
@@ -359,17 +775,36 @@ func (t *FilesPrivateChaincode) delete(stub shim.ChaincodeStubInterface, args []
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = stub.DelPrivateData("collectionFiles", ipfshashNameIndexKey)
+	err = stub.DelPrivateData(filesCollection, ipfshashNameIndexKey)
 	if err != nil {
 		return shim.Error("Failed to delete state:" + err.Error())
 	}
 
 	// Finally, delete private details of file
-	err = stub.DelPrivateData("collectionFilePrivateDetails", fileDeleteInput.Name)
+	err = stub.DelPrivateData(detailsCollection, fileDeleteInput.Name)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
+	// ==== Append the delete to the history~name index getFileHistory/getFileProvenance read ====
+	if err := recordFileHistoryEntry(stub, filesCollection, fileDeleteInput.Name, true, nil); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := setFileLifecycleEvent(stub, "FileDeleted", events.SigFileDeleted, events.FileDeletedEvent{
+		Name:      fileToDelete.Name,
+		IPFShash:  fileToDelete.IPFShash,
+		Owner:     fileToDelete.Owner,
+		TxID:      stub.GetTxID(),
+		Timestamp: txTimestamp.Seconds,
+	}); err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -381,8 +816,10 @@ func (t *FilesPrivateChaincode) transferFile(stub shim.ChaincodeStubInterface, a
 	fmt.Println("- start transfer file")
 
 	type fileTransferTransientInput struct {
-		Name  string `json:"name"`
-		Owner string `json:"owner"`
+		Name            string `json:"name"`
+		Owner           string `json:"owner"`
+		Institution     string `json:"institution"`
+		DestInstitution string `json:"destInstitution"` //optional; set only when the file is moving to a different institution
 	}
 
 	if len(args) != 0 {
@@ -415,8 +852,20 @@ func (t *FilesPrivateChaincode) transferFile(stub shim.ChaincodeStubInterface, a
 	if len(fileTransferInput.Owner) == 0 {
 		return shim.Error("owner field must be a non-empty string")
 	}
+	if len(fileTransferInput.Institution) == 0 {
+		return shim.Error("institution field must be a non-empty string")
+	}
+	if fileTransferInput.DestInstitution != "" {
+		if whitelisted, err := isInstitutionWhitelisted(stub, fileTransferInput.DestInstitution); err != nil {
+			return shim.Error(err.Error())
+		} else if !whitelisted {
+			return shim.Error("destInstitution is not whitelisted: " + fileTransferInput.DestInstitution)
+		}
+	}
 
-	fileAsBytes, err := stub.GetPrivateData("collectionFiles", fileTransferInput.Name)
+	sourceFilesCollection := filesCollectionFor(fileTransferInput.Institution)
+
+	fileAsBytes, err := stub.GetPrivateData(sourceFilesCollection, fileTransferInput.Name)
 	if err != nil {
 		return shim.Error("Failed to get file:" + err.Error())
 	} else if fileAsBytes == nil {
@@ -428,13 +877,93 @@ func (t *FilesPrivateChaincode) transferFile(stub shim.ChaincodeStubInterface, a
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+
+	// ==== Only the current owner or a supervisor may transfer a file ====
+	callerID, err := cid.GetID(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if callerID != fileToTransfer.Owner {
+		if err := requireRole(stub, "supervisor"); err != nil {
+			return accessDenied("ERR_FORBIDDEN", "caller must be the current owner or a supervisor")
+		}
+	}
+
+	previousOwner := fileToTransfer.Owner
 	fileToTransfer.Owner = fileTransferInput.Owner //change the owner
 
-	fileJSONasBytes, _ := json.Marshal(fileToTransfer)
-	err = stub.PutPrivateData("collectionFiles", fileToTransfer.Name, fileJSONasBytes) //rewrite the file
+	crossInstitution := fileTransferInput.DestInstitution != "" && fileTransferInput.DestInstitution != fileTransferInput.Institution
+
+	if crossInstitution {
+		fileToTransfer.Institution = fileTransferInput.DestInstitution
+
+		fileJSONasBytes, _ := json.Marshal(fileToTransfer)
+		if err := stub.PutPrivateData(filesCollectionFor(fileTransferInput.DestInstitution), fileToTransfer.Name, fileJSONasBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.DelPrivateData(sourceFilesCollection, fileToTransfer.Name); err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// ==== Move the ipfshash~name index entry with the file, the same way initFile creates
+		// it and delete removes it, so ipfshash-based lookups stay accurate after the move ====
+		indexName := "ipfshash~name"
+		ipfshashNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{fileToTransfer.IPFShash, fileToTransfer.Name})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.DelPrivateData(sourceFilesCollection, ipfshashNameIndexKey); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := stub.PutPrivateData(filesCollectionFor(fileTransferInput.DestInstitution), ipfshashNameIndexKey, []byte{0x00}); err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// ==== Record the cross-institution transfer so both sides have a hash-verifiable copy ====
+		transferRecordBytes, err := json.Marshal(fileToTransfer)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		transferCollection := transferCollectionFor(fileTransferInput.Institution, fileTransferInput.DestInstitution)
+		if err := stub.PutPrivateData(transferCollection, fileToTransfer.Name, transferRecordBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// ==== Record the departure in the source collection's history and the arrival in the
+		// dest collection's history, so getFileHistory/getFileProvenance stay accurate on both
+		// sides of a cross-institution move ====
+		if err := recordFileHistoryEntry(stub, sourceFilesCollection, fileToTransfer.Name, true, nil); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := recordFileHistoryEntry(stub, filesCollectionFor(fileTransferInput.DestInstitution), fileToTransfer.Name, false, fileJSONasBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		fileJSONasBytes, _ := json.Marshal(fileToTransfer)
+		err = stub.PutPrivateData(sourceFilesCollection, fileToTransfer.Name, fileJSONasBytes) //rewrite the file
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		if err := recordFileHistoryEntry(stub, sourceFilesCollection, fileToTransfer.Name, false, fileJSONasBytes); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	txTimestamp, err := stub.GetTxTimestamp()
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	if err := setFileLifecycleEvent(stub, "FileTransferred", events.SigFileTransferred, events.FileTransferredEvent{
+		Name:          fileToTransfer.Name,
+		IPFShash:      fileToTransfer.IPFShash,
+		Owner:         fileToTransfer.Owner,
+		PreviousOwner: previousOwner,
+		TxID:          stub.GetTxID(),
+		Timestamp:     txTimestamp.Seconds,
+	}); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println("- end transferFile (success)")
 	return shim.Success(nil)
@@ -453,14 +982,15 @@ func (t *FilesPrivateChaincode) transferFile(stub shim.ChaincodeStubInterface, a
 // ===========================================================================================
 func (t *FilesPrivateChaincode) getFilesByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting institution, startKey, endKey")
 	}
 
-	startKey := args[0]
-	endKey := args[1]
+	institution := args[0]
+	startKey := args[1]
+	endKey := args[2]
 
-	resultsIterator, err := stub.GetPrivateDataByRange("collectionFiles", startKey, endKey)
+	resultsIterator, err := stub.GetPrivateDataByRange(filesCollectionFor(institution), startKey, endKey)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -496,6 +1026,601 @@ func (t *FilesPrivateChaincode) getFilesByRange(stub shim.ChaincodeStubInterface
 	return shim.Success(buffer.Bytes())
 }
 
+// ===========================================================================================
+// history~name index - the change history (writes and deletes) of each file, kept in the
+// file's collection_<institution>_Files collection and read back by getFileHistory and
+// getFileProvenance
+// ===========================================================================================
+// fileHistoryEntry is one entry in the manually-maintained history~name index: a single
+// write (or delete) of a file record, keyed by name + zero-padded timestamp + TxId so that
+// GetPrivateDataByPartialCompositeKey returns entries back in chronological order.
+type fileHistoryEntry struct {
+	TxId      string          `json:"txId"`
+	Timestamp int64           `json:"timestamp"`
+	IsDelete  bool            `json:"isDelete"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// recordFileHistoryEntry appends an entry to filesCollection's history~name index for name.
+// Called by every function that writes or deletes a file record (initFile, transferFile,
+// delete, updateFileHash) so getFileHistory/getFileProvenance have something to read back.
+func recordFileHistoryEntry(stub shim.ChaincodeStubInterface, filesCollection string, name string, isDelete bool, value []byte) error {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return err
+	}
+
+	// The key orders entries chronologically so getFileProvenance can walk them as a sequence
+	// of owner transitions. TxIDs are hashes, not chronological, so they cannot break ties
+	// between two mutations that land in the same key component; nanosecond precision is used
+	// instead of just Seconds; collisions finer than that are not expected in practice.
+	nanos := txTimestamp.Seconds*1e9 + int64(txTimestamp.Nanos)
+	historyKey, err := stub.CreateCompositeKey(fileHistoryIndexName, []string{name, fmt.Sprintf("%020d", nanos), stub.GetTxID()})
+	if err != nil {
+		return err
+	}
+
+	entry := fileHistoryEntry{
+		TxId:      stub.GetTxID(),
+		Timestamp: txTimestamp.Seconds,
+		IsDelete:  isDelete,
+	}
+	if !isDelete {
+		entry.Value = value
+	}
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return stub.PutPrivateData(filesCollection, historyKey, entryBytes)
+}
+
+// ===========================================================================================
+// getFileHistory returns the history~name index entries recorded for a file, in the same
+// {TxId, Timestamp, IsDelete, Value} shape GetHistoryForKey would have returned had this
+// chaincode stored file records in the public world state instead of a private data
+// collection. args is [name, institution]; institution is mandatory since history is scoped
+// to the one collection it was recorded against.
+// ===========================================================================================
+func (t *FilesPrivateChaincode) getFileHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting name and institution of the file to query")
+	}
+	name := args[0]
+	institution := args[1]
+	filesCollection := filesCollectionFor(institution)
+
+	resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(filesCollection, fileHistoryIndexName, []string{name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	// buffer is a JSON array containing history entries
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if bArrayMemberAlreadyWritten {
+			buffer.WriteString(",")
+		}
+		buffer.Write(response.Value)
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	fmt.Printf("- getFileHistory queryResult:\n%s\n", buffer.String())
+
+	return shim.Success(buffer.Bytes())
+}
+
+// fileOwnerTransition describes a single change of custody for a file
+type fileOwnerTransition struct {
+	PreviousOwner string `json:"previousOwner"`
+	NewOwner      string `json:"newOwner"`
+	TxId          string `json:"txId"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+// ===========================================================================================
+// getFileProvenance - walks the file's history~name index and condenses it into the chain of
+// owner transitions (previous owner -> new owner), so auditors can trace custody without
+// re-reading the full index. args is [name, institution]; see getFileHistory.
+// ===========================================================================================
+func (t *FilesPrivateChaincode) getFileProvenance(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting name and institution of the file to query")
+	}
+	name := args[0]
+	institution := args[1]
+	filesCollection := filesCollectionFor(institution)
+
+	resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(filesCollection, fileHistoryIndexName, []string{name})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var transitions []fileOwnerTransition
+	var previousOwner string
+	for resultsIterator.HasNext() {
+		response, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		var entry fileHistoryEntry
+		if err := json.Unmarshal(response.Value, &entry); err != nil {
+			return shim.Error("Failed to decode JSON of: " + string(response.Value))
+		}
+
+		if entry.IsDelete {
+			previousOwner = ""
+			continue
+		}
+
+		var historicFile file
+		if err := json.Unmarshal(entry.Value, &historicFile); err != nil {
+			return shim.Error("Failed to decode JSON of: " + string(entry.Value))
+		}
+
+		if previousOwner != "" && historicFile.Owner != previousOwner {
+			transitions = append(transitions, fileOwnerTransition{
+				PreviousOwner: previousOwner,
+				NewOwner:      historicFile.Owner,
+				TxId:          entry.TxId,
+				Timestamp:     entry.Timestamp,
+			})
+		}
+		previousOwner = historicFile.Owner
+	}
+
+	provenanceBytes, err := json.Marshal(transitions)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(provenanceBytes)
+}
+
+// ===========================================================================================
+// queryFilesByOwner queries the collection_<institution>_Files collection given in args[1] (or,
+// when it is omitted, every whitelisted institution's) for files belonging to a given owner,
+// using a CouchDB Mango selector. This requires those private data collections to be backed
+// by CouchDB (stateDatabase: CouchDB in core.yaml / the channel's collection config),
+// since range queries alone can't filter on arbitrary JSON fields.
+//
+// Deployment requirements for queryFilesByOwner/queryFiles/queryFilesWithPagination:
+//   - Every endorsing peer must run with CORE_LEDGER_STATE_STATEDATABASE=CouchDB (core.yaml's
+//     ledger.state.stateDatabase: CouchDB) - LevelDB peers reject GetPrivateDataQueryResult.
+//   - The collections config passed to the chaincode definition must list a
+//     collection_<institution>_Files entry for every whitelisted institution, e.g.:
+//       {
+//         "name": "collection_OrphanageA_Files",
+//         "policy": "OR('Org1MSP.member')",
+//         "requiredPeerCount": 0,
+//         "maxPeerCount": 3,
+//         "blockToLive": 0,
+//         "memberOnlyRead": true
+//       }
+//   - To keep Mango selectors on docType/owner efficient at scale, define a CouchDB index for
+//     each collection, e.g. an index document named "owner-index" with
+//     {"index":{"fields":["docType","owner"]},"name":"owner-index"}, shipped under the
+//     chaincode package's META-INF/statedb/couchdb/collections/collection_<institution>_Files/
+//     indexes/ directory so it is installed alongside the collection.
+// ===========================================================================================
+func (t *FilesPrivateChaincode) queryFilesByOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting owner and optional institution")
+	}
+
+	owner := args[0]
+	institution := ""
+	if len(args) == 2 {
+		institution = args[1]
+	}
+
+	queryString := fmt.Sprintf(`{"selector":{"docType":"file","owner":"%s"}}`, owner)
+
+	queryResults, err := t.getQueryResultForQueryString(stub, queryString, institution)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(queryResults)
+}
+
+// ===========================================================================================
+// queryFiles accepts an arbitrary Mango-style JSON selector in args[0] and an optional
+// institution in args[1], for ad-hoc front-end listing (e.g. "all files for child X assigned to
+// social worker Y"). When institution is omitted, every whitelisted institution's collection is
+// searched and the results merged.
+// ===========================================================================================
+func (t *FilesPrivateChaincode) queryFiles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting queryString and optional institution")
+	}
+
+	queryString := args[0]
+	institution := ""
+	if len(args) == 2 {
+		institution = args[1]
+	}
+
+	queryResults, err := t.getQueryResultForQueryString(stub, queryString, institution)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(queryResults)
+}
+
+// ===========================================================================================
+// queryFilesWithPagination accepts a Mango-style JSON selector, a page size, a bookmark, and the
+// institution to query, returning one page of matching files along with the bookmark and
+// fetched record count needed to fetch the next page. Pagination bookmarks are specific to a
+// single CouchDB collection, so institution is mandatory here (unlike the other query functions).
+// ===========================================================================================
+func (t *FilesPrivateChaincode) queryFilesWithPagination(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting queryString, pageSize, bookmark, institution")
+	}
+
+	queryString := args[0]
+
+	pageSize, err := strconv.ParseInt(args[1], 10, 32)
+	if err != nil {
+		return shim.Error("pageSize must be an integer")
+	}
+	bookmark := args[2]
+	institution := args[3]
+
+	queryResults, responseMetadata, err := t.getQueryResultForQueryStringWithPagination(stub, queryString, int32(pageSize), bookmark, filesCollectionFor(institution))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	paginatedResponse := fmt.Sprintf(
+		`{"records":%s, "fetchedRecordsCount":%d, "bookmark":"%s"}`,
+		queryResults, responseMetadata.FetchedRecordsCount, responseMetadata.Bookmark,
+	)
+
+	return shim.Success([]byte(paginatedResponse))
+}
+
+// getQueryResultForQueryString executes the given Mango selector against the Files collection(s)
+// for institution (or every whitelisted institution, if institution is "") and returns the
+// matching records as a JSON array of {Key, Record}.
+func (t *FilesPrivateChaincode) getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString string, institution string) ([]byte, error) {
+	collections, err := candidateFilesCollections(stub, institution)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+	bArrayMemberAlreadyWritten := false
+
+	for _, collection := range collections {
+		resultsIterator, err := stub.GetPrivateDataQueryResult(collection, queryString)
+		if err != nil {
+			continue
+		}
+		collectionBuffer, err := bufferFromIterator(resultsIterator)
+		resultsIterator.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		records := strings.TrimSuffix(strings.TrimPrefix(collectionBuffer.String(), "["), "]")
+		if records == "" {
+			continue
+		}
+		if bArrayMemberAlreadyWritten {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString(records)
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", buffer.String())
+
+	return buffer.Bytes(), nil
+}
+
+// paginationMetadata mirrors pb.QueryResponseMetadata's shape for the client-side pagination
+// below
+type paginationMetadata struct {
+	FetchedRecordsCount int32
+	Bookmark            string
+}
+
+// getQueryResultForQueryStringWithPagination is the paginated counterpart of
+// getQueryResultForQueryString, operating against a single named collection. Fabric's private
+// data rich queries (unlike GetQueryResultWithPagination against the public world state) have no
+// server-side pagination support, so this paginates client-side over the full result set: the
+// bookmark is the decimal offset of the next record to return.
+func (t *FilesPrivateChaincode) getQueryResultForQueryStringWithPagination(stub shim.ChaincodeStubInterface, queryString string, pageSize int32, bookmark string, collection string) ([]byte, *paginationMetadata, error) {
+	resultsIterator, err := stub.GetPrivateDataQueryResult(collection, queryString)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	offset := int32(0)
+	if bookmark != "" {
+		parsedOffset, err := strconv.ParseInt(bookmark, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bookmark must be a decimal offset: %v", err)
+		}
+		offset = int32(parsedOffset)
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+	bArrayMemberAlreadyWritten := false
+	var index, fetched int32
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if index < offset {
+			index++
+			continue
+		}
+		if fetched >= pageSize {
+			break
+		}
+		if bArrayMemberAlreadyWritten {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString(
+			fmt.Sprintf(
+				`{"Key":"%s", "Record":%s}`,
+				queryResponse.Key, queryResponse.Value,
+			),
+		)
+		bArrayMemberAlreadyWritten = true
+		index++
+		fetched++
+	}
+	buffer.WriteString("]")
+
+	fmt.Printf("- getQueryResultForQueryStringWithPagination queryResult:\n%s\n", buffer.String())
+
+	return buffer.Bytes(), &paginationMetadata{FetchedRecordsCount: fetched, Bookmark: strconv.FormatInt(int64(offset + fetched), 10)}, nil
+}
+
+// bufferFromIterator drains a private data query iterator into a JSON array of {Key, Record}
+func bufferFromIterator(resultsIterator shim.StateQueryIteratorInterface) (*bytes.Buffer, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if bArrayMemberAlreadyWritten {
+			buffer.WriteString(",")
+		}
+
+		buffer.WriteString(
+			fmt.Sprintf(
+				`{"Key":"%s", "Record":%s}`,
+				queryResponse.Key, queryResponse.Value,
+			),
+		)
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("]")
+
+	return &buffer, nil
+}
+
+// ===========================================================================================
+// getCallerIdentity resolves the invoking identity's MSPID, OU, and role attribute via the CID
+// library, for ACL debugging
+// ===========================================================================================
+func (t *FilesPrivateChaincode) getCallerIdentity(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	roleValue, roleFound, err := cid.GetAttributeValue(stub, "role")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	response := struct {
+		ID    string `json:"id"`
+		MSPID string `json:"mspId"`
+		OU    string `json:"ou,omitempty"`
+		Role  string `json:"role,omitempty"`
+	}{ID: id, MSPID: mspID}
+	if len(cert.Subject.OrganizationalUnit) > 0 {
+		response.OU = strings.Join(cert.Subject.OrganizationalUnit, ",")
+	}
+	if roleFound {
+		response.Role = roleValue
+	}
+
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(responseBytes)
+}
+
+// ===========================================================================================
+// updateFileHash - replace a file's IPFS CID (and content digest) after re-verifying the new
+// CID against the supplied sha256 content digest, same as initFile. Only the current owner or
+// a supervisor may do this, matching transferFile's ACL posture.
+// ===========================================================================================
+func (t *FilesPrivateChaincode) updateFileHash(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("- start update file hash")
+
+	type fileHashTransientInput struct {
+		Name          string `json:"name"`
+		IPFShash      string `json:"ipfshash"`
+		ContentSHA256 string `json:"contentSha256"`
+		Institution   string `json:"institution"`
+	}
+
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Private file data must be passed in transient map.")
+	}
+
+	transMap, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("Error getting transient: " + err.Error())
+	}
+
+	fileHashJsonBytes, ok := transMap["file_hash"]
+	if !ok {
+		return shim.Error("file_hash must be a key in the transient map")
+	}
+	if len(fileHashJsonBytes) == 0 {
+		return shim.Error("file_hash value in the transient map must be a non-empty JSON string")
+	}
+
+	var fileHashInput fileHashTransientInput
+	if err := json.Unmarshal(fileHashJsonBytes, &fileHashInput); err != nil {
+		return shim.Error("Failed to decode JSON of: " + string(fileHashJsonBytes))
+	}
+
+	if len(fileHashInput.Name) == 0 {
+		return shim.Error("name field must be a non-empty string")
+	}
+	if len(fileHashInput.IPFShash) == 0 {
+		return shim.Error("ipfshash field must be a non-empty string")
+	}
+	if len(fileHashInput.ContentSHA256) == 0 {
+		return shim.Error("contentSha256 field must be a non-empty string")
+	}
+	if len(fileHashInput.Institution) == 0 {
+		return shim.Error("institution field must be a non-empty string")
+	}
+
+	canonicalCID, err := validateAndCanonicalizeCID(fileHashInput.IPFShash, fileHashInput.ContentSHA256)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	filesCollection := filesCollectionFor(fileHashInput.Institution)
+
+	fileAsBytes, err := stub.GetPrivateData(filesCollection, fileHashInput.Name)
+	if err != nil {
+		return shim.Error("Failed to get file: " + err.Error())
+	} else if fileAsBytes == nil {
+		return shim.Error("File does not exist: " + fileHashInput.Name)
+	}
+
+	var fileToUpdate file
+	if err := json.Unmarshal(fileAsBytes, &fileToUpdate); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	callerID, err := cid.GetID(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if callerID != fileToUpdate.Owner {
+		if err := requireRole(stub, "supervisor"); err != nil {
+			return accessDenied("ERR_FORBIDDEN", "caller must be the current owner or a supervisor")
+		}
+	}
+
+	fileToUpdate.IPFShash = canonicalCID
+	fileToUpdate.ContentSHA256 = fileHashInput.ContentSHA256
+
+	fileJSONasBytes, err := json.Marshal(fileToUpdate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := stub.PutPrivateData(filesCollection, fileToUpdate.Name, fileJSONasBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := recordFileHistoryEntry(stub, filesCollection, fileToUpdate.Name, false, fileJSONasBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fmt.Println("- end update file hash (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================================================
+// verifyFileHash - checks a hex-encoded sha256 digest against the multihash digest embedded in
+// the CID stored for a file, returning {"match": true/false}
+// ===========================================================================================
+func (t *FilesPrivateChaincode) verifyFileHash(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 || len(args) > 3 {
+		return shim.Error("Incorrect number of arguments. Expecting name, sha256Hex, and optional institution")
+	}
+	name := args[0]
+	sha256Hex := args[1]
+	institution := ""
+	if len(args) == 3 {
+		institution = args[2]
+	}
+
+	collections, err := candidateFilesCollections(stub, institution)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var fileAsBytes []byte
+	for _, collection := range collections {
+		candidateBytes, err := stub.GetPrivateData(collection, name)
+		if err == nil && candidateBytes != nil {
+			fileAsBytes = candidateBytes
+			break
+		}
+	}
+	if fileAsBytes == nil {
+		return shim.Error("File does not exist: " + name)
+	}
+
+	var storedFile file
+	if err := json.Unmarshal(fileAsBytes, &storedFile); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// validateAndCanonicalizeCID skips the digest comparison for an empty digest, so an empty
+	// sha256Hex is reported as no match rather than passed through
+	match := sha256Hex != ""
+	if _, err := validateAndCanonicalizeCID(storedFile.IPFShash, sha256Hex); err != nil {
+		match = false
+	}
+
+	response, err := json.Marshal(struct {
+		Match bool `json:"match"`
+	}{Match: match})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(response)
+}
+
 func main() {
 	err := shim.Start(&FilesPrivateChaincode{})
 	if err != nil {
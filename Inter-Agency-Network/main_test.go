@@ -0,0 +1,569 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/attrmgr"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+
+	"github.com/Blockchaincarechildren/institutionalized-children/Inter-Agency-Network/pkg/events"
+)
+
+// testInstitutions is the whitelist every test chaincode instance is Init'd with.
+var testInstitutions = []string{"OrphanageA", "OrphanageB"}
+
+// newIdentity builds a serialized MSP identity - a self-signed X.509 cert carrying the Fabric
+// CA attribute extension - suitable for shimtest.MockStub.Creator, so tests can drive
+// requireRole/requireAdmin/cid.* exactly as a real peer would.
+func newIdentity(t *testing.T, mspID string, ou string, attrs map[string]string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	var organizationalUnit []string
+	if ou != "" {
+		organizationalUnit = []string{ou}
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName:         "test-user",
+			OrganizationalUnit: organizationalUnit,
+		},
+		NotBefore:             time.Unix(0, 0),
+		NotAfter:              time.Unix(0, 0).Add(100 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+
+	if len(attrs) > 0 {
+		attrsJSON, err := json.Marshal(attrmgr.Attributes{Attrs: attrs})
+		if err != nil {
+			t.Fatalf("failed to marshal cert attributes: %v", err)
+		}
+		// ExtraExtensions (not Extensions) is what x509.CreateCertificate serializes.
+		template.ExtraExtensions = []pkix.Extension{{Id: attrmgr.AttrOID, Value: attrsJSON}}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	sid := &msp.SerializedIdentity{Mspid: mspID, IdBytes: certPEM}
+	idBytes, err := proto.Marshal(sid)
+	if err != nil {
+		t.Fatalf("failed to marshal serialized identity: %v", err)
+	}
+	return idBytes
+}
+
+// isAccessDenied reports whether message is the {code,reason} JSON accessDenied produces for an
+// ERR_FORBIDDEN check, as opposed to some other failure further down the call.
+func isAccessDenied(message string) bool {
+	var denied struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal([]byte(message), &denied); err != nil {
+		return false
+	}
+	return denied.Code == "ERR_FORBIDDEN"
+}
+
+// mustJSON marshals v or fails the test.
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to marshal %#v: %v", v, err)
+	}
+	return b
+}
+
+// newTestStub spins up a fresh chaincode instance and Inits it with transient, defaulting to a
+// two-institution whitelist when transient doesn't already set institution_whitelist.
+func newTestStub(t *testing.T, transient map[string][]byte) *shimtest.MockStub {
+	t.Helper()
+
+	stub := shimtest.NewMockStub("files", new(FilesPrivateChaincode))
+	if transient == nil {
+		transient = map[string][]byte{}
+	}
+	if _, ok := transient["institution_whitelist"]; !ok {
+		transient["institution_whitelist"] = mustJSON(t, testInstitutions)
+	}
+	stub.TransientMap = transient
+
+	res := stub.MockInit("init-tx", nil)
+	if res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	return stub
+}
+
+func TestAccessControl(t *testing.T) {
+	caseworker := newIdentity(t, "Org1MSP", "", map[string]string{"role": "caseworker"})
+	supervisor := newIdentity(t, "Org1MSP", "", map[string]string{"role": "supervisor"})
+	noRole := newIdentity(t, "Org1MSP", "", nil)
+	admin := newIdentity(t, "Org1MSP", "admin-ou", map[string]string{"role": "admin"})
+	wrongOrgAdmin := newIdentity(t, "Org2MSP", "admin-ou", map[string]string{"role": "admin"})
+
+	t.Run("initFile requires caseworker role", func(t *testing.T) {
+		stub := newTestStub(t, nil)
+
+		fileInput := mustJSON(t, map[string]interface{}{
+			"name": "child-001.pdf", "ipfshash": testCID1, "contentSha256": testCID1SHA256,
+			"size": 10, "owner": "caseworkerA", "folio": 1, "institution": "OrphanageA",
+		})
+
+		stub.Creator = noRole
+		stub.TransientMap = map[string][]byte{"file": fileInput}
+		res := stub.MockInvoke("tx1", [][]byte{[]byte("initFile")})
+		if res.Status == shim.OK {
+			t.Fatalf("expected initFile to be denied for an identity with no role")
+		}
+
+		stub.Creator = caseworker
+		stub.TransientMap = map[string][]byte{"file": fileInput}
+		res = stub.MockInvoke("tx2", [][]byte{[]byte("initFile")})
+		if res.Status != shim.OK {
+			t.Fatalf("initFile as caseworker failed: %s", res.Message)
+		}
+	})
+
+	t.Run("transferFile requires owner or supervisor", func(t *testing.T) {
+		stub := newTestStub(t, nil)
+		seedFile(t, stub, caseworker, "child-002.pdf", "caseworkerA", "OrphanageA")
+
+		transferInput := mustJSON(t, map[string]interface{}{
+			"name": "child-002.pdf", "owner": "caseworkerB", "institution": "OrphanageA",
+		})
+
+		stub.Creator = noRole
+		stub.TransientMap = map[string][]byte{"file_owner": transferInput}
+		res := stub.MockInvoke("tx3", [][]byte{[]byte("transferFile")})
+		if res.Status == shim.OK {
+			t.Fatalf("expected transferFile to be denied for a non-owner, non-supervisor caller")
+		}
+
+		stub.Creator = supervisor
+		stub.TransientMap = map[string][]byte{"file_owner": transferInput}
+		res = stub.MockInvoke("tx4", [][]byte{[]byte("transferFile")})
+		if res.Status != shim.OK {
+			t.Fatalf("transferFile as supervisor failed: %s", res.Message)
+		}
+	})
+
+	t.Run("delete requires admin role", func(t *testing.T) {
+		stub := newTestStub(t, nil)
+		seedFile(t, stub, caseworker, "child-003.pdf", "caseworkerA", "OrphanageA")
+
+		deleteInput := mustJSON(t, map[string]interface{}{"name": "child-003.pdf", "institution": "OrphanageA"})
+
+		stub.Creator = caseworker
+		stub.TransientMap = map[string][]byte{"file_delete": deleteInput}
+		res := stub.MockInvoke("tx5", [][]byte{[]byte("delete")})
+		if !isAccessDenied(res.Message) {
+			t.Fatalf("expected delete to be denied for a caseworker, got: %s", res.Message)
+		}
+
+		// shimtest.MockStub.DelPrivateData is hardcoded to return "Not Implemented", so delete
+		// can never fully succeed against the mock; what's checkable here is that the ACL
+		// itself let the admin identity through to that point.
+		stub.Creator = admin
+		stub.TransientMap = map[string][]byte{"file_delete": deleteInput}
+		res = stub.MockInvoke("tx6", [][]byte{[]byte("delete")})
+		if isAccessDenied(res.Message) {
+			t.Fatalf("admin should have passed the ACL check, got: %s", res.Message)
+		}
+	})
+
+	t.Run("delete also enforces a configured admin MSPID", func(t *testing.T) {
+		stub := newTestStub(t, map[string][]byte{
+			"admin_config": mustJSON(t, map[string]string{"adminMspId": "Org1MSP", "adminOu": "admin-ou"}),
+		})
+		seedFile(t, stub, caseworker, "child-004.pdf", "caseworkerA", "OrphanageA")
+
+		deleteInput := mustJSON(t, map[string]interface{}{"name": "child-004.pdf", "institution": "OrphanageA"})
+
+		// role=admin but from the wrong MSP: requireAdmin must reject it even though
+		// requireRole alone would have let it through.
+		stub.Creator = wrongOrgAdmin
+		stub.TransientMap = map[string][]byte{"file_delete": deleteInput}
+		res := stub.MockInvoke("tx7", [][]byte{[]byte("delete")})
+		if !isAccessDenied(res.Message) {
+			t.Fatalf("expected delete to be denied for an admin from a non-configured MSP, got: %s", res.Message)
+		}
+
+		stub.Creator = admin
+		stub.TransientMap = map[string][]byte{"file_delete": deleteInput}
+		res = stub.MockInvoke("tx8", [][]byte{[]byte("delete")})
+		if isAccessDenied(res.Message) {
+			t.Fatalf("the configured admin MSP/OU should have passed the ACL check, got: %s", res.Message)
+		}
+	})
+
+	t.Run("getCallerIdentity reports MSPID and role", func(t *testing.T) {
+		stub := newTestStub(t, nil)
+		stub.Creator = caseworker
+		res := stub.MockInvoke("tx9", [][]byte{[]byte("getCallerIdentity")})
+		if res.Status != shim.OK {
+			t.Fatalf("getCallerIdentity failed: %s", res.Message)
+		}
+
+		var identity struct {
+			MSPID string `json:"mspId"`
+			Role  string `json:"role"`
+		}
+		if err := json.Unmarshal(res.Payload, &identity); err != nil {
+			t.Fatalf("failed to decode getCallerIdentity payload: %v", err)
+		}
+		if identity.MSPID != "Org1MSP" || identity.Role != "caseworker" {
+			t.Fatalf("unexpected identity: %+v", identity)
+		}
+	})
+}
+
+// TestInitFileRequiresContentSha256 checks that omitting contentSha256 is rejected rather than
+// silently skipping the CID/digest cross-check validateAndCanonicalizeCID performs.
+func TestInitFileRequiresContentSha256(t *testing.T) {
+	caseworker := newIdentity(t, "Org1MSP", "", map[string]string{"role": "caseworker"})
+	stub := newTestStub(t, nil)
+
+	fileInput := mustJSON(t, map[string]interface{}{
+		"name": "child-050.pdf", "ipfshash": testCID1, "size": 10,
+		"owner": "caseworkerA", "folio": 1, "institution": "OrphanageA",
+	})
+	stub.Creator = caseworker
+	stub.TransientMap = map[string][]byte{"file": fileInput}
+	res := stub.MockInvoke("tx-init-no-digest", [][]byte{[]byte("initFile")})
+	if res.Status == shim.OK {
+		t.Fatalf("expected initFile to reject a missing contentSha256")
+	}
+}
+
+// seedFile drives initFile as caseworker so other tests have a file to operate on.
+func seedFile(t *testing.T, stub *shimtest.MockStub, caseworker []byte, name, owner, institution string) {
+	t.Helper()
+	fileInput := mustJSON(t, map[string]interface{}{
+		"name": name, "ipfshash": testCID1, "contentSha256": testCID1SHA256,
+		"size": 10, "owner": owner, "folio": 1, "institution": institution,
+	})
+	stub.Creator = caseworker
+	stub.TransientMap = map[string][]byte{"file": fileInput}
+	res := stub.MockInvoke("seed-"+name, [][]byte{[]byte("initFile")})
+	if res.Status != shim.OK {
+		t.Fatalf("failed to seed file %s: %s", name, res.Message)
+	}
+}
+
+// testCID1/testCID1SHA256/testCID2/testCID2SHA256 are a real CIDv0 and the hex-encoded sha256
+// digest it embeds, computed offline with github.com/ipfs/go-cid so validateAndCanonicalizeCID
+// accepts them without a network dependency in tests.
+const (
+	testCID1       = "QmaozNR7DZHQK1ZcU9p7QdrshMvXqWK6gpu5rmrkPdT3L4"
+	testCID1SHA256 = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	testCID2       = "QmYJFspa4AaqW4J2SDB5wUu3q2A8GxMFgRddL6jV3JWYVZ"
+	testCID2SHA256 = "93f7e40e427dcac7ed3e1941e754935cedd89c6e8797a26586bcc750d00048ac"
+)
+
+// historyEntriesFor reads back filesCollection's history~name index for name by inspecting
+// stub.PvtState directly. shimtest.MockStub.GetPrivateDataByPartialCompositeKey - the API
+// getFileHistory/getFileProvenance actually call - is hardcoded to return "Not Implemented",
+// so the index can't be exercised end to end through MockInvoke; this walks the same
+// collection state recordFileHistoryEntry writes to instead.
+func historyEntriesFor(t *testing.T, stub *shimtest.MockStub, filesCollection, name string) []fileHistoryEntry {
+	t.Helper()
+
+	var entries []fileHistoryEntry
+	for key, value := range stub.PvtState[filesCollection] {
+		// Composite keys always start with \x00 (shim.CreateCompositeKey); plain keys like a
+		// file's own name don't, and SplitCompositeKey panics on them, so skip anything that
+		// isn't shaped like one before calling it. Other composite keys, such as the
+		// ipfshash~name index, are skipped by the objectType check below.
+		if len(key) == 0 || key[0] != 0x00 {
+			continue
+		}
+		objectType, attributes, err := stub.SplitCompositeKey(key)
+		if err != nil {
+			continue
+		}
+		if objectType != fileHistoryIndexName || len(attributes) == 0 || attributes[0] != name {
+			continue
+		}
+		var entry fileHistoryEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			t.Fatalf("failed to decode history entry for key %q: %v", key, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// TestRecordFileHistoryEntryOrdersByNanosecondTimestamp guards against a same-second collision
+// sorting two history entries out of chronological order. TxIDs are hashes, not chronological,
+// so the first write here deliberately gets the lexicographically-larger TxID - if the
+// composite key ever goes back to ordering on seconds alone, this test catches it.
+func TestRecordFileHistoryEntryOrdersByNanosecondTimestamp(t *testing.T) {
+	stub := shimtest.NewMockStub("files", new(FilesPrivateChaincode))
+	collection := filesCollectionFor("OrphanageA")
+	name := "child-040.pdf"
+
+	stub.TxID = "zzz-txn-first"
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: 1700000000, Nanos: 100}
+	if err := recordFileHistoryEntry(stub, collection, name, false, []byte(`{"owner":"caseworkerA"}`)); err != nil {
+		t.Fatalf("recordFileHistoryEntry (first) failed: %v", err)
+	}
+
+	stub.TxID = "aaa-txn-second"
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: 1700000000, Nanos: 200}
+	if err := recordFileHistoryEntry(stub, collection, name, false, []byte(`{"owner":"caseworkerB"}`)); err != nil {
+		t.Fatalf("recordFileHistoryEntry (second) failed: %v", err)
+	}
+
+	var keys []string
+	for key := range stub.PvtState[collection] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 history keys, got %d", len(keys))
+	}
+
+	var first, second fileHistoryEntry
+	if err := json.Unmarshal(stub.PvtState[collection][keys[0]], &first); err != nil {
+		t.Fatalf("failed to decode first entry: %v", err)
+	}
+	if err := json.Unmarshal(stub.PvtState[collection][keys[1]], &second); err != nil {
+		t.Fatalf("failed to decode second entry: %v", err)
+	}
+
+	if first.TxId != "zzz-txn-first" || second.TxId != "aaa-txn-second" {
+		t.Fatalf("history entries sorted out of chronological order: got %q then %q", first.TxId, second.TxId)
+	}
+}
+
+// TestFileLifecycle drives initFile -> transferFile -> transferFile -> delete through
+// MockInvoke and checks that every step recorded a history~name entry. The cross-institution
+// transfer branch and a real delete both end up calling shimtest.MockStub.DelPrivateData,
+// which is hardcoded to return "Not Implemented", so this sticks to a same-institution
+// transfer and only checks delete's ACL outcome rather than its end state.
+func TestFileLifecycle(t *testing.T) {
+	caseworker := newIdentity(t, "Org1MSP", "", map[string]string{"role": "caseworker"})
+	supervisor := newIdentity(t, "Org1MSP", "", map[string]string{"role": "supervisor"})
+	admin := newIdentity(t, "Org1MSP", "admin-ou", map[string]string{"role": "admin"})
+
+	stub := newTestStub(t, nil)
+	filesCollection := filesCollectionFor("OrphanageA")
+	name := "child-010.pdf"
+
+	seedFile(t, stub, caseworker, name, "caseworkerA", "OrphanageA")
+	if entries := historyEntriesFor(t, stub, filesCollection, name); len(entries) != 1 {
+		t.Fatalf("expected 1 history entry after initFile, got %d", len(entries))
+	} else if entries[0].IsDelete {
+		t.Fatalf("initFile's history entry must not be marked as a delete")
+	}
+
+	transferTo := func(owner string, txID string) {
+		t.Helper()
+		// Transferring as the owner would require the owner string to match the caller's
+		// cid.GetID() exactly; a supervisor can move the file regardless of current owner.
+		stub.Creator = supervisor
+		stub.TransientMap = map[string][]byte{"file_owner": mustJSON(t, map[string]interface{}{
+			"name": name, "owner": owner, "institution": "OrphanageA",
+		})}
+		res := stub.MockInvoke(txID, [][]byte{[]byte("transferFile")})
+		if res.Status != shim.OK {
+			t.Fatalf("transferFile to %s failed: %s", owner, res.Message)
+		}
+	}
+
+	transferTo("caseworkerB", "tx-transfer-1")
+	transferTo("caseworkerC", "tx-transfer-2")
+
+	entries := historyEntriesFor(t, stub, filesCollection, name)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 history entries after two transfers, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.IsDelete {
+			t.Fatalf("same-institution transfer must append, not delete, a history entry")
+		}
+		var recorded file
+		if err := json.Unmarshal(entry.Value, &recorded); err != nil {
+			t.Fatalf("failed to decode recorded file value: %v", err)
+		}
+	}
+
+	deleteInput := mustJSON(t, map[string]interface{}{"name": name, "institution": "OrphanageA"})
+
+	stub.Creator = caseworker
+	stub.TransientMap = map[string][]byte{"file_delete": deleteInput}
+	res := stub.MockInvoke("tx-delete-denied", [][]byte{[]byte("delete")})
+	if !isAccessDenied(res.Message) {
+		t.Fatalf("expected delete to be denied for a caseworker, got: %s", res.Message)
+	}
+
+	stub.Creator = admin
+	stub.TransientMap = map[string][]byte{"file_delete": deleteInput}
+	res = stub.MockInvoke("tx-delete-admin", [][]byte{[]byte("delete")})
+	if isAccessDenied(res.Message) {
+		t.Fatalf("admin should have passed the ACL check, got: %s", res.Message)
+	}
+
+	// getFileHistory/getFileProvenance can't be driven through MockInvoke at all: both call
+	// GetPrivateDataByPartialCompositeKey, which MockStub always errors on. Confirm that's
+	// still the failure mode here, so a future shimtest upgrade that fixes the mock is
+	// noticed (this assertion starts failing) rather than the gap staying silent.
+	stub.Creator = caseworker
+	res = stub.MockInvoke("tx-history", [][]byte{[]byte("getFileHistory"), []byte(name), []byte("OrphanageA")})
+	if res.Status == shim.OK {
+		t.Fatalf("expected getFileHistory to fail against shimtest.MockStub until GetPrivateDataByPartialCompositeKey is implemented there")
+	}
+}
+
+// drainEvent reads the one chaincode event a MockInvoke call is expected to have emitted via
+// stub.SetEvent, failing the test if none arrived.
+func drainEvent(t *testing.T, stub *shimtest.MockStub) *pb.ChaincodeEvent {
+	t.Helper()
+	select {
+	case event := <-stub.ChaincodeEventsChannel:
+		return event
+	default:
+		t.Fatalf("expected a chaincode event to have been emitted")
+		return nil
+	}
+}
+
+// TestFileLifecycleEvents checks that initFile/transferFile/delete each emit their
+// events.SigFileXxx envelope with the expected name and payload, via the real
+// stub.ChaincodeEventsChannel (one of the few MockStub capabilities that actually works).
+func TestFileLifecycleEvents(t *testing.T) {
+	caseworker := newIdentity(t, "Org1MSP", "", map[string]string{"role": "caseworker"})
+	supervisor := newIdentity(t, "Org1MSP", "", map[string]string{"role": "supervisor"})
+	admin := newIdentity(t, "Org1MSP", "admin-ou", map[string]string{"role": "admin"})
+
+	stub := newTestStub(t, nil)
+	name := "child-020.pdf"
+
+	seedFile(t, stub, caseworker, name, "caseworkerA", "OrphanageA")
+	createdEvent := drainEvent(t, stub)
+	if createdEvent.EventName != "FileCreated" {
+		t.Fatalf("expected event name FileCreated, got %s", createdEvent.EventName)
+	}
+	var created events.FileCreatedEvent
+	if err := events.UnpackLog(events.SigFileCreated, createdEvent.Payload, &created); err != nil {
+		t.Fatalf("failed to unpack FileCreated payload: %v", err)
+	}
+	if created.Name != name || created.Owner != "caseworkerA" || created.IPFShash == "" {
+		t.Fatalf("unexpected FileCreated payload: %+v", created)
+	}
+
+	stub.Creator = supervisor
+	stub.TransientMap = map[string][]byte{"file_owner": mustJSON(t, map[string]interface{}{
+		"name": name, "owner": "caseworkerB", "institution": "OrphanageA",
+	})}
+	res := stub.MockInvoke("tx-transfer", [][]byte{[]byte("transferFile")})
+	if res.Status != shim.OK {
+		t.Fatalf("transferFile failed: %s", res.Message)
+	}
+	transferredEvent := drainEvent(t, stub)
+	if transferredEvent.EventName != "FileTransferred" {
+		t.Fatalf("expected event name FileTransferred, got %s", transferredEvent.EventName)
+	}
+	var transferred events.FileTransferredEvent
+	if err := events.UnpackLog(events.SigFileTransferred, transferredEvent.Payload, &transferred); err != nil {
+		t.Fatalf("failed to unpack FileTransferred payload: %v", err)
+	}
+	if transferred.Owner != "caseworkerB" || transferred.PreviousOwner != "caseworkerA" {
+		t.Fatalf("unexpected FileTransferred payload: %+v", transferred)
+	}
+
+	// Using UnpackLog with the wrong sig must be rejected rather than silently decoding.
+	var wrongShape events.FileCreatedEvent
+	if err := events.UnpackLog(events.SigFileCreated, transferredEvent.Payload, &wrongShape); err != events.ErrEventSignatureMismatch {
+		t.Fatalf("expected ErrEventSignatureMismatch decoding a FileTransferred payload as FileCreated, got %v", err)
+	}
+
+	stub.Creator = admin
+	stub.TransientMap = map[string][]byte{"file_delete": mustJSON(t, map[string]interface{}{
+		"name": name, "institution": "OrphanageA",
+	})}
+	// shimtest.MockStub.DelPrivateData is hardcoded to return "Not Implemented", so delete
+	// fails before it reaches setFileLifecycleEvent; no FileDeleted event is emitted against
+	// the mock, and this documents that rather than asserting an event that can't occur here.
+	res = stub.MockInvoke("tx-delete", [][]byte{[]byte("delete")})
+	if res.Status == shim.OK {
+		t.Fatalf("expected delete to fail against shimtest.MockStub's unimplemented DelPrivateData")
+	}
+	select {
+	case event := <-stub.ChaincodeEventsChannel:
+		t.Fatalf("did not expect a chaincode event from a failed delete, got %+v", event)
+	default:
+	}
+}
+
+// TestQueryFilesRequiresCouchDB documents a real limitation of testing
+// queryFilesByOwner/queryFiles/queryFilesWithPagination with shimtest: all three are built on
+// GetPrivateDataQueryResult, which - like GetPrivateDataByRange and
+// GetPrivateDataByPartialCompositeKey - shimtest.MockStub always returns "Not Implemented" for.
+// There is no in-memory CouchDB Mango-selector implementation to query against, so these
+// functions can only get real integration coverage against a peer running with CouchDB as its
+// state database (see the deployment notes on queryFilesByOwner), not a unit test in this
+// module.
+//
+// getQueryResultForQueryString (used by queryFilesByOwner/queryFiles) silently skips a
+// collection whose query errors, so against the mock it reports success with an empty result
+// rather than failing - that's asserted below so a change to that error handling is noticed.
+// getQueryResultForQueryStringWithPagination does propagate the error, so
+// queryFilesWithPagination is asserted to fail outright.
+func TestQueryFilesRequiresCouchDB(t *testing.T) {
+	caseworker := newIdentity(t, "Org1MSP", "", map[string]string{"role": "caseworker"})
+	stub := newTestStub(t, nil)
+	seedFile(t, stub, caseworker, "child-030.pdf", "caseworkerA", "OrphanageA")
+
+	stub.Creator = caseworker
+	res := stub.MockInvoke("tx-query-by-owner", [][]byte{[]byte("queryFilesByOwner"), []byte("caseworkerA"), []byte("OrphanageA")})
+	if res.Status != shim.OK || string(res.Payload) != "[]" {
+		t.Fatalf("expected queryFilesByOwner to report success with an empty result against shimtest.MockStub, got status=%d payload=%s", res.Status, res.Payload)
+	}
+
+	res = stub.MockInvoke("tx-query-ad-hoc", [][]byte{
+		[]byte("queryFiles"), []byte(`{"selector":{"docType":"file"}}`), []byte("OrphanageA"),
+	})
+	if res.Status != shim.OK || string(res.Payload) != "[]" {
+		t.Fatalf("expected queryFiles to report success with an empty result against shimtest.MockStub, got status=%d payload=%s", res.Status, res.Payload)
+	}
+
+	res = stub.MockInvoke("tx-query-paginated", [][]byte{
+		[]byte("queryFilesWithPagination"), []byte(`{"selector":{"docType":"file"}}`), []byte("10"), []byte(""), []byte("OrphanageA"),
+	})
+	if res.Status == shim.OK {
+		t.Fatalf("expected queryFilesWithPagination to fail against shimtest.MockStub until GetPrivateDataQueryResult is implemented there")
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package events defines the typed, versioned chaincode events emitted by the
+// institutional file chaincode, and the helper SDK clients use to decode them
+// safely instead of parsing ad-hoc JSON. The wire format mirrors the typed ABI
+// event unpacking pattern used by go-ethereum's accounts/abi/bind: every event
+// carries a stable signature and version alongside its payload.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Event signatures. Bump the trailing version when a payload's shape changes
+// in a backwards-incompatible way, and keep the old signature's struct around
+// so older events already on the ledger can still be decoded.
+const (
+	SigFileCreated     = "file.created.v1"
+	SigFileTransferred = "file.transferred.v1"
+	SigFileDeleted     = "file.deleted.v1"
+)
+
+var (
+	// ErrNoEventSignature is returned when the payload does not carry a sig field at all.
+	ErrNoEventSignature = errors.New("events: payload carries no event signature")
+	// ErrEventSignatureMismatch is returned when the payload's sig does not match the one UnpackLog was asked to decode.
+	ErrEventSignatureMismatch = errors.New("events: event signature does not match the target type")
+)
+
+// FileCreatedEvent is the payload for SigFileCreated.
+type FileCreatedEvent struct {
+	Name      string `json:"name"`
+	IPFShash  string `json:"ipfshash"`
+	Owner     string `json:"owner"`
+	TxID      string `json:"txID"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// FileTransferredEvent is the payload for SigFileTransferred.
+type FileTransferredEvent struct {
+	Name          string `json:"name"`
+	IPFShash      string `json:"ipfshash"`
+	Owner         string `json:"owner"`
+	PreviousOwner string `json:"previousOwner"`
+	TxID          string `json:"txID"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+// FileDeletedEvent is the payload for SigFileDeleted.
+type FileDeletedEvent struct {
+	Name      string `json:"name"`
+	IPFShash  string `json:"ipfshash"`
+	Owner     string `json:"owner"`
+	TxID      string `json:"txID"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// envelope is the wire format passed to stub.SetEvent: {"sig":..., "version":..., "payload":...}
+type envelope struct {
+	Sig     string          `json:"sig"`
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Pack wraps payload in the versioned envelope ready to hand to stub.SetEvent.
+func Pack(sig string, version int, payload interface{}) ([]byte, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Sig: sig, Version: version, Payload: payloadBytes})
+}
+
+// UnpackLog decodes a versioned event envelope, checking that its signature equals sig before
+// unmarshaling the payload into out. Callers pass the SigXxx constant for the struct they're
+// decoding into, e.g. UnpackLog(events.SigFileCreated, payload, &events.FileCreatedEvent{}).
+func UnpackLog(sig string, payload []byte, out interface{}) error {
+	var env envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return err
+	}
+	if env.Sig == "" {
+		return ErrNoEventSignature
+	}
+	if env.Sig != sig {
+		return ErrEventSignatureMismatch
+	}
+	return json.Unmarshal(env.Payload, out)
+}
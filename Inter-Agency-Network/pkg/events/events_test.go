@@ -0,0 +1,145 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package events
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPackUnpackRoundTrip(t *testing.T) {
+	payload := FileCreatedEvent{
+		Name:      "child-001.pdf",
+		IPFShash:  "QmaozNR7DZHQK1ZcU9p7QdrshMvXqWK6gpu5rmrkPdT3L4",
+		Owner:     "caseworkerA",
+		TxID:      "tx1",
+		Timestamp: 1700000000,
+	}
+
+	packed, err := Pack(SigFileCreated, 1, payload)
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+
+	var decoded FileCreatedEvent
+	if err := UnpackLog(SigFileCreated, packed, &decoded); err != nil {
+		t.Fatalf("UnpackLog failed: %v", err)
+	}
+	if decoded != payload {
+		t.Fatalf("round-tripped payload does not match: got %+v, want %+v", decoded, payload)
+	}
+}
+
+func TestUnpackLogRejectsWrongSignature(t *testing.T) {
+	packed, err := Pack(SigFileCreated, 1, FileCreatedEvent{Name: "child-001.pdf"})
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+
+	var out FileTransferredEvent
+	if err := UnpackLog(SigFileTransferred, packed, &out); err != ErrEventSignatureMismatch {
+		t.Fatalf("expected ErrEventSignatureMismatch, got %v", err)
+	}
+}
+
+func TestUnpackLogRejectsMissingSignature(t *testing.T) {
+	// A plain, pre-envelope JSON payload - the shape this chaincode emitted before events were
+	// versioned - carries no "sig" field at all.
+	legacyPayload := []byte(`{"name":"child-001.pdf","owner":"caseworkerA"}`)
+
+	var out FileCreatedEvent
+	if err := UnpackLog(SigFileCreated, legacyPayload, &out); err != ErrNoEventSignature {
+		t.Fatalf("expected ErrNoEventSignature decoding an unversioned payload, got %v", err)
+	}
+}
+
+// TestUnpackLogIgnoresUnknownFields simulates a forward-compatible schema change: a future
+// SigFileCreated payload that has grown a field this version of the struct doesn't know about.
+// Older consumers of the envelope must keep decoding the fields they do know, not fail outright.
+func TestUnpackLogIgnoresUnknownFields(t *testing.T) {
+	type fileCreatedV2 struct {
+		FileCreatedEvent
+		CaseID string `json:"caseId"`
+	}
+
+	packed, err := Pack(SigFileCreated, 1, fileCreatedV2{
+		FileCreatedEvent: FileCreatedEvent{Name: "child-001.pdf", Owner: "caseworkerA"},
+		CaseID:           "case-42",
+	})
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+
+	var decoded FileCreatedEvent
+	if err := UnpackLog(SigFileCreated, packed, &decoded); err != nil {
+		t.Fatalf("UnpackLog failed on a payload with an extra field: %v", err)
+	}
+	if decoded.Name != "child-001.pdf" || decoded.Owner != "caseworkerA" {
+		t.Fatalf("unexpected decoded payload: %+v", decoded)
+	}
+}
+
+// TestUnpackLogSignatureBumpIsolatesOldReaders simulates a backwards-incompatible payload
+// change: a hypothetical "file.created.v2" signature with a different shape. A reader still
+// asking for SigFileCreated (v1) must get ErrEventSignatureMismatch rather than attempting to
+// decode the v2 shape into the v1 struct, which is exactly the mechanism that lets the v1
+// struct be kept around unmodified once a v2 signature ships.
+func TestUnpackLogSignatureBumpIsolatesOldReaders(t *testing.T) {
+	const sigFileCreatedV2 = "file.created.v2"
+
+	type fileCreatedV2 struct {
+		Name      string `json:"name"`
+		IPFShash  string `json:"ipfshash"`
+		Owner     string `json:"owner"`
+		CaseID    string `json:"caseId"`
+		TxID      string `json:"txID"`
+		Timestamp int64  `json:"timestamp"`
+	}
+
+	packed, err := Pack(sigFileCreatedV2, 2, fileCreatedV2{Name: "child-001.pdf", CaseID: "case-42"})
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+
+	var v1 FileCreatedEvent
+	if err := UnpackLog(SigFileCreated, packed, &v1); err != ErrEventSignatureMismatch {
+		t.Fatalf("expected ErrEventSignatureMismatch decoding a v2 payload as v1, got %v", err)
+	}
+
+	var v2 fileCreatedV2
+	if err := UnpackLog(sigFileCreatedV2, packed, &v2); err != nil {
+		t.Fatalf("UnpackLog failed decoding the v2 payload as v2: %v", err)
+	}
+	if v2.Name != "child-001.pdf" || v2.CaseID != "case-42" {
+		t.Fatalf("unexpected decoded v2 payload: %+v", v2)
+	}
+}
+
+func TestUnpackLogRejectsMalformedEnvelope(t *testing.T) {
+	var out FileCreatedEvent
+	if err := UnpackLog(SigFileCreated, []byte("not json"), &out); err == nil {
+		t.Fatalf("expected an error decoding a malformed envelope")
+	}
+}
+
+// TestPackEmbedsPayloadAsRawJSON confirms Pack's envelope really does carry the payload as a
+// nested JSON object (not a double-encoded string), since that's what lets UnpackLog's second
+// json.Unmarshal work directly against env.Payload.
+func TestPackEmbedsPayloadAsRawJSON(t *testing.T) {
+	packed, err := Pack(SigFileDeleted, 1, FileDeletedEvent{Name: "child-001.pdf"})
+	if err != nil {
+		t.Fatalf("Pack failed: %v", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(packed, &raw); err != nil {
+		t.Fatalf("failed to decode envelope as a generic map: %v", err)
+	}
+	if string(raw["payload"][0]) != "{" {
+		t.Fatalf("expected payload to be embedded as a JSON object, got %s", raw["payload"])
+	}
+}